@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
 	"strings"
 
@@ -77,25 +79,51 @@ func cmdAdd(args *skel.CmdArgs) error {
 	defer netns.Close()
 
 	sm := sriov.NewSriovManager()
-	err = sm.FillOriginalVfInfo(netConf)
-	if err != nil {
-		return fmt.Errorf("failed to get original vf information: %v", err)
-	}
-	defer func() {
+
+	// restored is true when this Add is recreating a pod sandbox for a
+	// "persistent" VF that a prior cmdDel left bound in this same netns,
+	// with the same ContainerID/IfName/MAC: in that case the VF is
+	// already configured and moved, so skip straight to re-running IPAM.
+	restored := tryRestorePersistentVF(netConf, args, netns, sm)
+
+	if !restored {
+		err = sm.FillOriginalVfInfo(netConf)
 		if err != nil {
-			err := netns.Do(func(_ ns.NetNS) error {
-				_, err := netlink.LinkByName(args.IfName)
-				return err
-			})
-			if err == nil {
-				_ = sm.ReleaseVF(netConf, args.IfName, netns)
+			return fmt.Errorf("failed to get original vf information: %v", err)
+		}
+		defer func() {
+			if err != nil {
+				err := netns.Do(func(_ ns.NetNS) error {
+					_, err := netlink.LinkByName(args.IfName)
+					return err
+				})
+				if err == nil {
+					// Undo any VF sysctls already applied before the failure
+					// while the VF is still in the netns under args.IfName,
+					// since the netconf never made it into the cache for
+					// cmdDel to revert them later.
+					if len(netConf.OrigSysctl) > 0 {
+						_ = netns.Do(func(_ ns.NetNS) error {
+							return utils.RevertSysctls(netConf.OrigSysctl, args.IfName)
+						})
+					}
+					_ = sm.ReleaseVF(netConf, args.IfName, netns)
+				}
+				// Reset the VF if failure occurs before the netconf is cached
+				if !netConf.ExternallyManaged {
+					_ = sm.ResetVFConfig(netConf)
+				}
+				// Likewise undo any PF sysctls already applied.
+				if len(netConf.OrigPFSysctl) > 0 {
+					_ = utils.RevertSysctls(netConf.OrigPFSysctl, netConf.Master)
+				}
+			}
+		}()
+		if !netConf.ExternallyManaged {
+			if err = sm.ApplyVFConfig(netConf); err != nil {
+				return fmt.Errorf("SRIOV-CNI failed to configure VF %q", err)
 			}
-			// Reset the VF if failure occurs before the netconf is cached
-			_ = sm.ResetVFConfig(netConf)
 		}
-	}()
-	if err := sm.ApplyVFConfig(netConf); err != nil {
-		return fmt.Errorf("SRIOV-CNI failed to configure VF %q", err)
 	}
 
 	result := &current.Result{}
@@ -104,7 +132,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		Sandbox: netns.Path(),
 	}}
 
-	if !netConf.DPDKMode {
+	if !netConf.DPDKMode && !restored {
 		err = sm.SetupVF(netConf, args.IfName, netns)
 
 		if err != nil {
@@ -178,12 +206,33 @@ func cmdAdd(args *skel.CmdArgs) error {
 		result = newResult
 	}
 
+	if !restored && len(netConf.PFSysctl) > 0 {
+		orig, sysctlErr := utils.ApplySysctls(netConf.PFSysctl, netConf.Master)
+		netConf.OrigPFSysctl = orig
+		if sysctlErr != nil {
+			err = fmt.Errorf("failed to apply pfSysctl: %v", sysctlErr)
+			return err
+		}
+	}
+
+	if !restored && len(netConf.Sysctl) > 0 {
+		err = netns.Do(func(_ ns.NetNS) error {
+			orig, sysctlErr := utils.ApplySysctls(netConf.Sysctl, args.IfName)
+			netConf.OrigSysctl = orig
+			return sysctlErr
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to apply sysctl: %v", err)
+			return err
+		}
+	}
+
 	// Cache NetConf for CmdDel
-	if err = utils.SaveNetConf(args.ContainerID, config.DefaultCNIDir, args.IfName, netConf); err != nil {
+	if err = utils.SaveNetConf(args.ContainerID, netConf.CNIDir, args.IfName, netConf); err != nil {
 		return fmt.Errorf("error saving NetConf %q", err)
 	}
 
-	allocator := utils.NewPCIAllocator(config.DefaultCNIDir)
+	allocator := utils.NewPCIAllocator(netConf.CNIDir)
 	// Mark the pci address as in used
 	if err = allocator.SaveAllocatedPCI(netConf.DeviceID, args.Netns); err != nil {
 		return fmt.Errorf("error saving the pci allocation for vf pci address %s: %v", netConf.DeviceID, err)
@@ -192,6 +241,51 @@ func cmdAdd(args *skel.CmdArgs) error {
 	return types.PrintResult(result, netConf.CNIVersion)
 }
 
+// tryRestorePersistentVF checks whether netConf describes a VF that a
+// prior cmdAdd left bound to args.ContainerID/IfName in "persistent" mode,
+// and that the VF is still there, in the target netns, with the expected
+// MAC. If so, it copies over the original VF state recorded back then and
+// returns true, so the caller can skip ApplyVFConfig/SetupVF.
+func tryRestorePersistentVF(netConf *config.NetConf, args *skel.CmdArgs, netns ns.NetNS, sm sriov.Manager) bool {
+	if !netConf.Persistent {
+		return false
+	}
+
+	cachedBytes, _, err := utils.ReadNetConf(netConf.CNIDir, args.ContainerID, args.IfName)
+	if err != nil || cachedBytes == nil {
+		return false
+	}
+
+	cached := &config.NetConf{}
+	if err := json.Unmarshal(cachedBytes, cached); err != nil || !cached.Persistent {
+		return false
+	}
+
+	allocator := utils.NewPCIAllocator(netConf.CNIDir)
+	allocatedNetns, err := allocator.GetAllocatedNetns(netConf.DeviceID)
+	if err != nil || allocatedNetns != args.Netns {
+		return false
+	}
+
+	// Compare the live VF state against the newly supplied netConf, not
+	// the stale cached one: if the NetworkAttachmentDefinition changed
+	// (e.g. a different vlan), that must fall through to a normal
+	// ApplyVFConfig/SetupVF rather than silently keeping the old state.
+	netConf.OrigVfState = cached.OrigVfState
+	if err := sm.CheckVFConfig(netConf, args.IfName, netns); err != nil {
+		netConf.OrigVfState = utils.VfState{}
+		return false
+	}
+
+	// The sysctls are already applied from the prior Add; carry over
+	// their originals rather than re-snapshotting (and overwriting) them
+	// from the VF's current, already-tuned state.
+	netConf.OrigSysctl = cached.OrigSysctl
+	netConf.OrigPFSysctl = cached.OrigPFSysctl
+
+	return true
+}
+
 func cmdDel(args *skel.CmdArgs) error {
 	netConf, cRefPath, err := config.LoadConfFromCache(args)
 	if err != nil {
@@ -206,7 +300,7 @@ func cmdDel(args *skel.CmdArgs) error {
 	}
 
 	defer func() {
-		if err == nil && cRefPath != "" {
+		if err == nil && cRefPath != "" && !netConf.Persistent {
 			_ = utils.CleanCachedNetConf(cRefPath)
 		}
 	}()
@@ -218,6 +312,14 @@ func cmdDel(args *skel.CmdArgs) error {
 		}
 	}
 
+	if netConf.Persistent {
+		// Leave the VF bound in the (possibly now-dead) pod netns, and
+		// keep the cached NetConf + PCI allocation: either a later
+		// cmdAdd for the same ContainerID/IfName picks it back up, or
+		// cmdGC reclaims it once the netns is actually gone.
+		return nil
+	}
+
 	// https://github.com/kubernetes/kubernetes/pull/35240
 	if args.Netns == "" {
 		return nil
@@ -230,12 +332,20 @@ func cmdDel(args *skel.CmdArgs) error {
 
 	sm := sriov.NewSriovManager()
 
-	/* ResetVFConfig resets a VF administratively. We must run ResetVFConfig
-	   before ReleaseVF because some drivers will error out if we try to
-	   reset netdev VF with trust off. So, reset VF MAC address via PF first.
-	*/
-	if err := sm.ResetVFConfig(netConf); err != nil {
-		return fmt.Errorf("cmdDel() error reseting VF: %q", err)
+	if !netConf.ExternallyManaged {
+		/* ResetVFConfig resets a VF administratively. We must run ResetVFConfig
+		   before ReleaseVF because some drivers will error out if we try to
+		   reset netdev VF with trust off. So, reset VF MAC address via PF first.
+		*/
+		if err = sm.ResetVFConfig(netConf); err != nil {
+			return fmt.Errorf("cmdDel() error reseting VF: %q", err)
+		}
+	}
+
+	if len(netConf.OrigPFSysctl) > 0 {
+		if err = utils.RevertSysctls(netConf.OrigPFSysctl, netConf.Master); err != nil {
+			return fmt.Errorf("cmdDel() error reverting pfSysctl: %v", err)
+		}
 	}
 
 	if !netConf.DPDKMode {
@@ -255,13 +365,21 @@ func cmdDel(args *skel.CmdArgs) error {
 		}
 		defer netns.Close()
 
+		if len(netConf.OrigSysctl) > 0 {
+			if err = netns.Do(func(_ ns.NetNS) error {
+				return utils.RevertSysctls(netConf.OrigSysctl, args.IfName)
+			}); err != nil {
+				return fmt.Errorf("cmdDel() error reverting sysctl: %v", err)
+			}
+		}
+
 		if err = sm.ReleaseVF(netConf, args.IfName, netns); err != nil {
 			return err
 		}
 	}
 
 	// Mark the pci address as released
-	allocator := utils.NewPCIAllocator(config.DefaultCNIDir)
+	allocator := utils.NewPCIAllocator(netConf.CNIDir)
 	if err = allocator.DeleteAllocatedPCI(netConf.DeviceID); err != nil {
 		return fmt.Errorf("error cleaning the pci allocation for vf pci address %s: %v", netConf.DeviceID, err)
 	}
@@ -269,10 +387,108 @@ func cmdDel(args *skel.CmdArgs) error {
 	return nil
 }
 
-func cmdCheck(_ *skel.CmdArgs) error {
+func cmdCheck(args *skel.CmdArgs) error {
+	netConf, _, err := config.LoadConfFromCache(args)
+	if err != nil {
+		return err
+	}
+
+	allocator := utils.NewPCIAllocator(netConf.CNIDir)
+	allocatedNetns, err := allocator.GetAllocatedNetns(netConf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("cmdCheck() error reading pci allocation for vf pci address %s: %v", netConf.DeviceID, err)
+	}
+	if allocatedNetns != args.Netns {
+		return fmt.Errorf("cmdCheck() vf pci address %s is allocated to netns %q, expected %q", netConf.DeviceID, allocatedNetns, args.Netns)
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("cmdCheck() failed to open netns %q: %v", args.Netns, err)
+	}
+	defer netns.Close()
+
+	sm := sriov.NewSriovManager()
+	if err := sm.CheckVFConfig(netConf, args.IfName, netns); err != nil {
+		return fmt.Errorf("cmdCheck() VF configuration does not match cached NetConf: %v", err)
+	}
+
+	return nil
+}
+
+// cmdGC reclaims VFs that a "persistent" cmdDel left bound in a pod netns
+// that has since gone away (e.g. the sandbox was never recreated). It is
+// invoked out-of-band of any single container, via the CNI spec 1.1
+// GC command, typically on a timer or node reconciliation pass.
+func cmdGC(args *skel.CmdArgs) error {
+	dataDir := config.DefaultCNIDir
+	if dir, err := config.LoadConfDataDir(args.StdinData); err == nil && dir != "" {
+		dataDir = dir
+	}
+
+	entries, err := utils.ListCachedNetConfs(dataDir)
+	if err != nil {
+		return fmt.Errorf("cmdGC() error listing cached NetConf in %q: %v", dataDir, err)
+	}
+
+	allocator := utils.NewPCIAllocator(dataDir)
+	sm := sriov.NewSriovManager()
+
+	for _, entry := range entries {
+		cached := &config.NetConf{}
+		if err := json.Unmarshal(entry.Bytes, cached); err != nil || !cached.Persistent {
+			continue
+		}
+
+		netnsPath, err := allocator.GetAllocatedNetns(cached.DeviceID)
+		if err != nil {
+			continue
+		}
+
+		if netnsPath != "" {
+			if _, statErr := os.Stat(netnsPath); statErr == nil {
+				// netns is still around; nothing to reclaim yet.
+				continue
+			}
+		}
+
+		if !cached.ExternallyManaged {
+			if err := sm.ResetVFConfig(cached); err != nil {
+				return fmt.Errorf("cmdGC() error resetting vf pci address %s: %v", cached.DeviceID, err)
+			}
+		}
+
+		if len(cached.OrigPFSysctl) > 0 {
+			if err := utils.RevertSysctls(cached.OrigPFSysctl, cached.Master); err != nil {
+				return fmt.Errorf("cmdGC() error reverting pfSysctl for vf pci address %s: %v", cached.DeviceID, err)
+			}
+		}
+
+		if err := allocator.DeleteAllocatedPCI(cached.DeviceID); err != nil {
+			return fmt.Errorf("cmdGC() error releasing pci allocation for vf pci address %s: %v", cached.DeviceID, err)
+		}
+
+		if err := utils.CleanCachedNetConf(entry.Path); err != nil {
+			return fmt.Errorf("cmdGC() error removing stale cache entry %q: %v", entry.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// cmdStatus is a no-op: sriov-cni has no external dependency (API server,
+// daemon) whose readiness it needs to report through the CNI spec 1.1
+// STATUS command.
+func cmdStatus(_ *skel.CmdArgs) error {
 	return nil
 }
 
 func main() {
-	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, "")
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:    cmdAdd,
+		Check:  cmdCheck,
+		Del:    cmdDel,
+		GC:     cmdGC,
+		Status: cmdStatus,
+	}, version.All, "")
 }
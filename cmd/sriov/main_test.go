@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/utils"
+)
+
+// fakeNetNS is a no-op stand-in for ns.NetNS: tryRestorePersistentVF never
+// actually uses it directly, only threading it through to sm.CheckVFConfig.
+type fakeNetNS struct{}
+
+func (f *fakeNetNS) Do(toRun func(ns.NetNS) error) error { return toRun(f) }
+func (f *fakeNetNS) Set() error                          { return nil }
+func (f *fakeNetNS) Path() string                        { return "" }
+func (f *fakeNetNS) Fd() uintptr                         { return 0 }
+func (f *fakeNetNS) Close() error                        { return nil }
+
+// fakeManager is a hand-rolled sriov.Manager stub: tryRestorePersistentVF
+// only ever calls CheckVFConfig on it.
+type fakeManager struct {
+	checkErr error
+}
+
+func (f *fakeManager) FillOriginalVfInfo(*config.NetConf) error { return nil }
+func (f *fakeManager) ApplyVFConfig(*config.NetConf) error      { return nil }
+func (f *fakeManager) ResetVFConfig(*config.NetConf) error      { return nil }
+func (f *fakeManager) SetupVF(*config.NetConf, string, ns.NetNS) error {
+	return nil
+}
+func (f *fakeManager) ReleaseVF(*config.NetConf, string, ns.NetNS) error {
+	return nil
+}
+func (f *fakeManager) CheckVFConfig(*config.NetConf, string, ns.NetNS) error {
+	return f.checkErr
+}
+
+var _ sriov.Manager = (*fakeManager)(nil)
+
+func cacheArgs() *skel.CmdArgs {
+	return &skel.CmdArgs{ContainerID: "cid1", Netns: "/var/run/netns/test1", IfName: "net1"}
+}
+
+func TestTryRestorePersistentVFNotPersistent(t *testing.T) {
+	netConf := &config.NetConf{Persistent: false}
+	args := cacheArgs()
+
+	restored := tryRestorePersistentVF(netConf, args, &fakeNetNS{}, &fakeManager{})
+	assert.False(t, restored)
+}
+
+func TestTryRestorePersistentVFNoCachedEntry(t *testing.T) {
+	netConf := &config.NetConf{Persistent: true, CNIDir: t.TempDir(), DeviceID: "0000:00:00.0"}
+	args := cacheArgs()
+
+	restored := tryRestorePersistentVF(netConf, args, &fakeNetNS{}, &fakeManager{})
+	assert.False(t, restored)
+}
+
+func TestTryRestorePersistentVFMatch(t *testing.T) {
+	dataDir := t.TempDir()
+	args := cacheArgs()
+
+	cached := &config.NetConf{
+		Persistent:   true,
+		CNIDir:       dataDir,
+		DeviceID:     "0000:00:00.0",
+		OrigSysctl:   map[string]string{"net.ipv4.conf.IFNAME.rp_filter": "1"},
+		OrigPFSysctl: map[string]string{"net.ipv4.conf.pf0.forwarding": "0"},
+	}
+	cached.OrigVfState.EffectiveMAC = "aa:bb:cc:dd:ee:ff"
+	require.NoError(t, utils.SaveNetConf(args.ContainerID, dataDir, args.IfName, cached))
+
+	allocator := utils.NewPCIAllocator(dataDir)
+	require.NoError(t, allocator.SaveAllocatedPCI(cached.DeviceID, args.Netns))
+
+	netConf := &config.NetConf{Persistent: true, CNIDir: dataDir, DeviceID: cached.DeviceID}
+
+	restored := tryRestorePersistentVF(netConf, args, &fakeNetNS{}, &fakeManager{})
+	assert.True(t, restored)
+	assert.Equal(t, cached.OrigVfState, netConf.OrigVfState)
+	assert.Equal(t, cached.OrigSysctl, netConf.OrigSysctl)
+	assert.Equal(t, cached.OrigPFSysctl, netConf.OrigPFSysctl)
+}
+
+func TestTryRestorePersistentVFNoMatchOnConfigDrift(t *testing.T) {
+	dataDir := t.TempDir()
+	args := cacheArgs()
+
+	cached := &config.NetConf{Persistent: true, CNIDir: dataDir, DeviceID: "0000:00:00.0"}
+	require.NoError(t, utils.SaveNetConf(args.ContainerID, dataDir, args.IfName, cached))
+
+	allocator := utils.NewPCIAllocator(dataDir)
+	require.NoError(t, allocator.SaveAllocatedPCI(cached.DeviceID, args.Netns))
+
+	netConf := &config.NetConf{Persistent: true, CNIDir: dataDir, DeviceID: cached.DeviceID}
+
+	// The VF no longer matches what cmdAdd recorded (e.g. the
+	// NetworkAttachmentDefinition changed), so CheckVFConfig fails and
+	// tryRestorePersistentVF must fall through to a normal Add.
+	restored := tryRestorePersistentVF(netConf, args, &fakeNetNS{}, &fakeManager{checkErr: assert.AnError})
+	assert.False(t, restored)
+	assert.Equal(t, utils.VfState{}, netConf.OrigVfState)
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/utils"
+)
+
+// DefaultCNIDir is the default directory under which sriov-cni caches
+// per-container NetConf and PCI allocation state.
+const DefaultCNIDir = "/var/lib/cni/sriov"
+
+// NetConf extends types.NetConf for sriov-cni
+type NetConf struct {
+	types.NetConf
+	DPDKMode      bool
+	Master        string
+	Vlan          *int   `json:"vlan"`
+	VlanQoS       *int   `json:"vlanQoS"`
+	DeviceID      string `json:"deviceID"`
+	VFID          int
+	HostIFNames   string
+	ContIFNames   string
+	MAC           string
+	Devicetype    string `json:"deviceType"`
+	Trust         string `json:"trust,omitempty"`
+	SpoofChk      string `json:"spoofchk,omitempty"`
+	LinkState     string `json:"link_state,omitempty"`
+	MaxTxRate     *int   `json:"max_tx_rate,omitempty"`
+	MinTxRate     *int   `json:"min_tx_rate,omitempty"`
+	RuntimeConfig struct {
+		Mac string `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+	LogLevel    string `json:"logLevel,omitempty"`
+	LogFile     string `json:"logFile,omitempty"`
+	CNIDir      string `json:"cniDir,omitempty"`
+	OrigVfState utils.VfState
+
+	// Sysctl is a map of "net.*" sysctl keys (with an "IFNAME" placeholder
+	// standing in for the pod interface name, e.g.
+	// "net.ipv4.conf.IFNAME.rp_filter") to apply to the VF once it has
+	// been moved into the container netns.
+	Sysctl map[string]string `json:"sysctl,omitempty"`
+	// PFSysctl is applied the same way as Sysctl, but to the PF in the
+	// host netns, with "IFNAME" standing in for the Master interface.
+	PFSysctl map[string]string `json:"pfSysctl,omitempty"`
+
+	// OrigSysctl and OrigPFSysctl cache the values overwritten by Sysctl
+	// and PFSysctl respectively, so cmdDel can revert them.
+	OrigSysctl   map[string]string `json:"origSysctl,omitempty"`
+	OrigPFSysctl map[string]string `json:"origPFSysctl,omitempty"`
+
+	// ExternallyManaged indicates the VF's administrative state (MAC,
+	// VLAN, QoS, trust, spoofchk, rate limits) is provisioned out-of-band
+	// (NIC firmware, an operator, a bonded PF team) and must not be
+	// touched by ApplyVFConfig/ResetVFConfig.
+	ExternallyManaged bool `json:"externallyManaged,omitempty"`
+
+	// RdmaIsolation, when set, moves the VF's RDMA device into the
+	// container netns alongside its netdev, so RoCE traffic in the pod
+	// is isolated to that namespace.
+	RdmaIsolation bool `json:"rdma,omitempty"`
+
+	// Persistent decouples VF release from cmdDel: the VF is left bound
+	// in the (possibly now-dead) pod netns, and the cached NetConf + PCI
+	// allocation are kept around for cmdGC to reclaim once the netns is
+	// actually gone, or for a later cmdAdd for the same ContainerID and
+	// IfName to pick back up without a MAC/IP change.
+	Persistent bool `json:"persistent,omitempty"`
+}
+
+// LoadConf parses and validates the CNI JSON config.
+func LoadConf(bytes []byte) (*NetConf, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %v", err)
+	}
+
+	if n.Master == "" {
+		return nil, fmt.Errorf(`"master" field is required. It specifies the host interface name to virtualize`)
+	}
+
+	if n.DeviceID == "" {
+		return nil, fmt.Errorf(`"deviceID" field is required. It specifies the VF PCI address`)
+	}
+
+	if n.CNIDir == "" {
+		n.CNIDir = DefaultCNIDir
+	}
+
+	if n.ExternallyManaged {
+		if n.MAC != "" || n.Vlan != nil || n.VlanQoS != nil || n.SpoofChk != "" ||
+			n.Trust != "" || n.MinTxRate != nil || n.MaxTxRate != nil || n.LinkState != "" {
+			return nil, fmt.Errorf("externallyManaged is true: mac/vlan/vlanQoS/spoofchk/trust/min_tx_rate/max_tx_rate/link_state must not be set")
+		}
+	}
+
+	vfid, err := utils.GetVfid(n.DeviceID, n.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VF id: %v", err)
+	}
+	n.VFID = vfid
+
+	return n, nil
+}
+
+// LoadConfFromCache loads the NetConf that was cached on cmdAdd, for use
+// in cmdDel and cmdCheck.
+func LoadConfFromCache(args *skel.CmdArgs) (*NetConf, string, error) {
+	netConf := &NetConf{}
+
+	dataDir := DefaultCNIDir
+	if n, err := LoadConfDataDir(args.StdinData); err == nil && n != "" {
+		dataDir = n
+	}
+
+	netConfBytes, confPath, err := utils.ReadNetConf(dataDir, args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, "", err
+	}
+	if netConfBytes == nil {
+		return nil, "", fmt.Errorf("cached NetConf not found for container %q interface %q", args.ContainerID, args.IfName)
+	}
+
+	if err := json.Unmarshal(netConfBytes, netConf); err != nil {
+		return nil, "", fmt.Errorf("failed to parse NetConf: %v", err)
+	}
+
+	return netConf, confPath, nil
+}
+
+// LoadConfDataDir extracts just the cniDir field from a CNI JSON config,
+// without LoadConf's full validation (which requires fields like "master"
+// and "deviceID" that are only populated per-pod and are not present in
+// the generic config GC and other out-of-band commands are invoked with).
+func LoadConfDataDir(bytes []byte) (string, error) {
+	n := &NetConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		return "", err
+	}
+	return n.CNIDir, nil
+}
+
+// GetMacAddressForResult returns the MAC address that should be reported
+// in the CNI result for the interface: the explicitly configured MAC if
+// one was given, otherwise the VF's original effective MAC.
+func GetMacAddressForResult(netConf *NetConf) string {
+	if netConf.MAC != "" {
+		return netConf.MAC
+	}
+	return netConf.OrigVfState.EffectiveMAC
+}
@@ -0,0 +1,412 @@
+package sriov
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/utils"
+)
+
+// Manager provides the methods main.go relies on to move a VF between
+// netns and configure its administrative state on the PF.
+type Manager interface {
+	FillOriginalVfInfo(conf *config.NetConf) error
+	ApplyVFConfig(conf *config.NetConf) error
+	ResetVFConfig(conf *config.NetConf) error
+	SetupVF(conf *config.NetConf, podifName string, netns ns.NetNS) error
+	ReleaseVF(conf *config.NetConf, podifName string, netns ns.NetNS) error
+	CheckVFConfig(conf *config.NetConf, podifName string, netns ns.NetNS) error
+}
+
+type sriovManager struct {
+	nLink utils.NetlinkManager
+}
+
+// NewSriovManager returns a Manager backed by the real netlink library.
+func NewSriovManager() Manager {
+	return &sriovManager{
+		nLink: &utils.MyNetlink{},
+	}
+}
+
+// FillOriginalVfInfo snapshots the VF's current administrative state on
+// the PF side, so it can be restored by ResetVFConfig on cmdDel. The VF's
+// host netdev name is always recorded, since SetupVF/ReleaseVF need it
+// regardless of who owns the VF's admin state.
+func (s *sriovManager) FillOriginalVfInfo(conf *config.NetConf) error {
+	hostIFName, err := utils.GetVFLinkName(conf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get VF netdevice name for %q: %v", conf.DeviceID, err)
+	}
+	conf.OrigVfState.HostIFName = hostIFName
+	conf.OrigVfState.OrigLinkName = hostIFName
+
+	if conf.ExternallyManaged {
+		// Admin state is owned out-of-band; we will never call
+		// ResetVFConfig to restore it, so there is nothing else to
+		// snapshot here.
+		return nil
+	}
+
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	attrs := pfLink.Attrs()
+	if attrs == nil || len(attrs.Vfs) <= conf.VFID {
+		return fmt.Errorf("cannot find VF %d on PF %q", conf.VFID, conf.Master)
+	}
+
+	vfInfo := attrs.Vfs[conf.VFID]
+
+	conf.OrigVfState.AdminMAC = vfInfo.Mac.String()
+	conf.OrigVfState.Vlan = vfInfo.Vlan
+	conf.OrigVfState.VlanQoS = vfInfo.Qos
+	conf.OrigVfState.SpoofChk = vfInfo.Spoofchk
+	conf.OrigVfState.Trust = vfInfo.Trust != 0
+	conf.OrigVfState.MinTxRate = int(vfInfo.MinTxRate)
+	conf.OrigVfState.MaxTxRate = int(vfInfo.MaxTxRate)
+	conf.OrigVfState.LinkState = vfInfo.LinkState
+	conf.OrigVfState.EffectiveMAC = vfInfo.Mac.String()
+
+	return nil
+}
+
+// ApplyVFConfig pushes the NIC-side VF settings requested in conf (MAC,
+// VLAN, QoS, spoofchk, trust, rate limits, link state) onto the PF.
+func (s *sriovManager) ApplyVFConfig(conf *config.NetConf) error {
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	if conf.Vlan != nil {
+		qos := 0
+		if conf.VlanQoS != nil {
+			qos = *conf.VlanQoS
+		}
+		if *conf.Vlan == 0 {
+			if err := s.nLink.LinkSetVfVlan(pfLink, conf.VFID, 0); err != nil {
+				return fmt.Errorf("failed to reset vlan for vf %d: %v", conf.VFID, err)
+			}
+		} else if err := s.nLink.LinkSetVfVlanQos(pfLink, conf.VFID, *conf.Vlan, qos); err != nil {
+			return fmt.Errorf("failed to set vlan %d (qos %d) for vf %d: %v", *conf.Vlan, qos, conf.VFID, err)
+		}
+	}
+
+	if conf.MAC != "" {
+		hwaddr, err := net.ParseMAC(conf.MAC)
+		if err != nil {
+			return fmt.Errorf("failed to parse MAC address %q: %v", conf.MAC, err)
+		}
+		if err := s.nLink.LinkSetVfHardwareAddr(pfLink, conf.VFID, hwaddr); err != nil {
+			return fmt.Errorf("failed to set MAC address %q for vf %d: %v", conf.MAC, conf.VFID, err)
+		}
+		conf.OrigVfState.EffectiveMAC = conf.MAC
+	}
+
+	if conf.SpoofChk != "" {
+		if err := s.nLink.LinkSetVfSpoofchk(pfLink, conf.VFID, conf.SpoofChk == "on"); err != nil {
+			return fmt.Errorf("failed to set spoofchk %q for vf %d: %v", conf.SpoofChk, conf.VFID, err)
+		}
+	}
+
+	if conf.Trust != "" {
+		if err := s.nLink.LinkSetVfTrust(pfLink, conf.VFID, conf.Trust == "on"); err != nil {
+			return fmt.Errorf("failed to set trust %q for vf %d: %v", conf.Trust, conf.VFID, err)
+		}
+	}
+
+	if conf.MinTxRate != nil || conf.MaxTxRate != nil {
+		minTxRate := 0
+		maxTxRate := 0
+		if conf.MinTxRate != nil {
+			minTxRate = *conf.MinTxRate
+		}
+		if conf.MaxTxRate != nil {
+			maxTxRate = *conf.MaxTxRate
+		}
+		if err := s.nLink.LinkSetVfRate(pfLink, conf.VFID, minTxRate, maxTxRate); err != nil {
+			return fmt.Errorf("failed to set tx rate for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	if conf.LinkState != "" {
+		state, err := linkStateFromString(conf.LinkState)
+		if err != nil {
+			return err
+		}
+		if err := s.nLink.LinkSetVfState(pfLink, conf.VFID, state); err != nil {
+			return fmt.Errorf("failed to set link state %q for vf %d: %v", conf.LinkState, conf.VFID, err)
+		}
+	}
+
+	return nil
+}
+
+// ResetVFConfig restores the VF's administrative state to what
+// FillOriginalVfInfo observed before sriov-cni touched it.
+func (s *sriovManager) ResetVFConfig(conf *config.NetConf) error {
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	if conf.Vlan != nil {
+		if err := s.nLink.LinkSetVfVlanQos(pfLink, conf.VFID, conf.OrigVfState.Vlan, conf.OrigVfState.VlanQoS); err != nil {
+			return fmt.Errorf("failed to reset vlan for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	if conf.MAC != "" {
+		hwaddr, err := net.ParseMAC(conf.OrigVfState.AdminMAC)
+		if err != nil {
+			return fmt.Errorf("failed to parse original MAC address %q: %v", conf.OrigVfState.AdminMAC, err)
+		}
+		if err := s.nLink.LinkSetVfHardwareAddr(pfLink, conf.VFID, hwaddr); err != nil {
+			return fmt.Errorf("failed to reset MAC address for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	if conf.SpoofChk != "" {
+		if err := s.nLink.LinkSetVfSpoofchk(pfLink, conf.VFID, conf.OrigVfState.SpoofChk); err != nil {
+			return fmt.Errorf("failed to reset spoofchk for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	if conf.MinTxRate != nil || conf.MaxTxRate != nil {
+		if err := s.nLink.LinkSetVfRate(pfLink, conf.VFID, conf.OrigVfState.MinTxRate, conf.OrigVfState.MaxTxRate); err != nil {
+			return fmt.Errorf("failed to reset tx rate for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	if conf.LinkState != "" {
+		if err := s.nLink.LinkSetVfState(pfLink, conf.VFID, conf.OrigVfState.LinkState); err != nil {
+			return fmt.Errorf("failed to reset link state for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	// Trust must be reset last: some drivers refuse to reset the MAC or
+	// VLAN of a VF once trust has been turned off.
+	if conf.Trust != "" {
+		if err := s.nLink.LinkSetVfTrust(pfLink, conf.VFID, conf.OrigVfState.Trust); err != nil {
+			return fmt.Errorf("failed to reset trust for vf %d: %v", conf.VFID, err)
+		}
+	}
+
+	return nil
+}
+
+// SetupVF moves the VF netdev into the target netns and renames it to
+// podifName.
+func (s *sriovManager) SetupVF(conf *config.NetConf, podifName string, netns ns.NetNS) error {
+	vfLink, err := s.nLink.LinkByName(conf.OrigVfState.HostIFName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup vf device %q: %v", conf.OrigVfState.HostIFName, err)
+	}
+
+	if err := s.nLink.LinkSetDown(vfLink); err != nil {
+		return fmt.Errorf("failed to set down vf device %q: %v", conf.OrigVfState.HostIFName, err)
+	}
+
+	if err := s.nLink.LinkSetNsFd(vfLink, int(netns.Fd())); err != nil {
+		return fmt.Errorf("failed to move vf device %q to netns: %v", conf.OrigVfState.HostIFName, err)
+	}
+
+	if err := netns.Do(func(_ ns.NetNS) error {
+		link, err := s.nLink.LinkByName(conf.OrigVfState.HostIFName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup vf device %q in container netns: %v", conf.OrigVfState.HostIFName, err)
+		}
+
+		if err := s.nLink.LinkSetName(link, podifName); err != nil {
+			return fmt.Errorf("failed to rename vf device %q to %q: %v", conf.OrigVfState.HostIFName, podifName, err)
+		}
+
+		if err := s.nLink.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to set up vf device %q: %v", podifName, err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if conf.RdmaIsolation {
+		if err := s.moveRdmaDeviceToNetns(conf, netns); err != nil {
+			return fmt.Errorf("failed to move rdma device for %q into container netns: %v", conf.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// moveRdmaDeviceToNetns discovers the RDMA device associated with the
+// VF's PCI address and moves it into netns, alongside the netdev. It
+// requires the RDMA subsystem to be in "exclusive" namespace mode: in
+// "shared" mode RDMA devices are visible from every netns and cannot be
+// isolated to the pod, so RoCE traffic in the pod would silently use the
+// wrong namespace.
+func (s *sriovManager) moveRdmaDeviceToNetns(conf *config.NetConf, netns ns.NetNS) error {
+	mode, err := utils.GetRdmaNamespaceMode()
+	if err != nil {
+		return fmt.Errorf("failed to query RDMA namespace mode: %v", err)
+	}
+	if mode != utils.RdmaNetnsModeExclusive {
+		return fmt.Errorf("RDMA subsystem is in %q namespace mode; rdma isolation requires %q mode (see: rdma system)", mode, utils.RdmaNetnsModeExclusive)
+	}
+
+	rdmaDevName, err := utils.GetRdmaDeviceName(conf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to get RDMA device name: %v", err)
+	}
+	if rdmaDevName == "" {
+		return fmt.Errorf("no RDMA device found for %q", conf.DeviceID)
+	}
+	conf.OrigVfState.RdmaDevName = rdmaDevName
+
+	rdmaLink, err := s.nLink.RdmaLinkByName(rdmaDevName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup rdma device %q: %v", rdmaDevName, err)
+	}
+
+	if err := s.nLink.RdmaLinkSetNsFd(rdmaLink, uint32(netns.Fd())); err != nil {
+		return fmt.Errorf("failed to move rdma device %q to netns: %v", rdmaDevName, err)
+	}
+
+	return nil
+}
+
+// ReleaseVF moves the VF netdev back to the host netns and restores its
+// original device name.
+func (s *sriovManager) ReleaseVF(conf *config.NetConf, podifName string, netns ns.NetNS) error {
+	initns, err := ns.GetCurrentNS()
+	if err != nil {
+		return fmt.Errorf("failed to get host netns: %v", err)
+	}
+	defer initns.Close()
+
+	if conf.RdmaIsolation && conf.OrigVfState.RdmaDevName != "" {
+		if err := netns.Do(func(_ ns.NetNS) error {
+			rdmaLink, err := s.nLink.RdmaLinkByName(conf.OrigVfState.RdmaDevName)
+			if err != nil {
+				return fmt.Errorf("failed to lookup rdma device %q in container netns: %v", conf.OrigVfState.RdmaDevName, err)
+			}
+			return s.nLink.RdmaLinkSetNsFd(rdmaLink, uint32(initns.Fd()))
+		}); err != nil {
+			return fmt.Errorf("failed to move rdma device %q back to host netns: %v", conf.OrigVfState.RdmaDevName, err)
+		}
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		link, err := s.nLink.LinkByName(podifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup vf device %q in container netns: %v", podifName, err)
+		}
+
+		if err := s.nLink.LinkSetDown(link); err != nil {
+			return fmt.Errorf("failed to set down vf device %q: %v", podifName, err)
+		}
+
+		if err := s.nLink.LinkSetName(link, conf.OrigVfState.HostIFName); err != nil {
+			return fmt.Errorf("failed to rename vf device %q to %q: %v", podifName, conf.OrigVfState.HostIFName, err)
+		}
+
+		if err := s.nLink.LinkSetNsFd(link, int(initns.Fd())); err != nil {
+			return fmt.Errorf("failed to move vf device %q to host netns: %v", conf.OrigVfState.HostIFName, err)
+		}
+
+		return nil
+	})
+}
+
+// CheckVFConfig verifies the VF's current state still matches conf, as
+// recorded when cmdAdd ran, returning a descriptive error on any drift a
+// periodic CNI CHECK should surface (e.g. a node reboot or an external
+// agent re-binding the VF to a different MAC).
+func (s *sriovManager) CheckVFConfig(conf *config.NetConf, podifName string, netns ns.NetNS) error {
+	expectedMAC := config.GetMacAddressForResult(conf)
+
+	if err := netns.Do(func(_ ns.NetNS) error {
+		link, err := s.nLink.LinkByName(podifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup interface %q: %v", podifName, err)
+		}
+
+		gotMAC := link.Attrs().HardwareAddr.String()
+		if expectedMAC != "" && gotMAC != expectedMAC {
+			return fmt.Errorf("interface %q has MAC address %q, expected %q", podifName, gotMAC, expectedMAC)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if conf.ExternallyManaged {
+		return nil
+	}
+
+	return s.checkPFVfState(conf)
+}
+
+// checkPFVfState verifies the VF's administrative state, as seen from the
+// PF, still matches conf.
+func (s *sriovManager) checkPFVfState(conf *config.NetConf) error {
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	attrs := pfLink.Attrs()
+	if attrs == nil || len(attrs.Vfs) <= conf.VFID {
+		return fmt.Errorf("cannot find VF %d on PF %q", conf.VFID, conf.Master)
+	}
+	vfInfo := attrs.Vfs[conf.VFID]
+
+	if conf.Vlan != nil && vfInfo.Vlan != *conf.Vlan {
+		return fmt.Errorf("VF %d has vlan %d, expected %d", conf.VFID, vfInfo.Vlan, *conf.Vlan)
+	}
+	if conf.VlanQoS != nil && vfInfo.Qos != *conf.VlanQoS {
+		return fmt.Errorf("VF %d has vlan qos %d, expected %d", conf.VFID, vfInfo.Qos, *conf.VlanQoS)
+	}
+	if conf.Trust != "" && (vfInfo.Trust != 0) != (conf.Trust == "on") {
+		return fmt.Errorf("VF %d has trust %v, expected %q", conf.VFID, vfInfo.Trust != 0, conf.Trust)
+	}
+	if conf.SpoofChk != "" && vfInfo.Spoofchk != (conf.SpoofChk == "on") {
+		return fmt.Errorf("VF %d has spoofchk %v, expected %q", conf.VFID, vfInfo.Spoofchk, conf.SpoofChk)
+	}
+	if conf.MinTxRate != nil && int(vfInfo.MinTxRate) != *conf.MinTxRate {
+		return fmt.Errorf("VF %d has min_tx_rate %d, expected %d", conf.VFID, vfInfo.MinTxRate, *conf.MinTxRate)
+	}
+	if conf.MaxTxRate != nil && int(vfInfo.MaxTxRate) != *conf.MaxTxRate {
+		return fmt.Errorf("VF %d has max_tx_rate %d, expected %d", conf.VFID, vfInfo.MaxTxRate, *conf.MaxTxRate)
+	}
+	if conf.LinkState != "" {
+		expected, err := linkStateFromString(conf.LinkState)
+		if err != nil {
+			return err
+		}
+		if vfInfo.LinkState != expected {
+			return fmt.Errorf("VF %d has link_state %d, expected %d", conf.VFID, vfInfo.LinkState, expected)
+		}
+	}
+
+	return nil
+}
+
+func linkStateFromString(s string) (uint32, error) {
+	switch s {
+	case "auto":
+		return 0, nil // netlink.VF_LINK_STATE_AUTO
+	case "enable":
+		return 1, nil // netlink.VF_LINK_STATE_ENABLE
+	case "disable":
+		return 2, nil // netlink.VF_LINK_STATE_DISABLE
+	}
+	return 0, fmt.Errorf("invalid link_state %q, must be one of auto/enable/disable", s)
+}
@@ -0,0 +1,113 @@
+package sriov
+
+import (
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/sriov-cni/pkg/utils/mocks"
+)
+
+// fakeNetNS runs Do's callback in the current (test) goroutine's netns,
+// so CheckVFConfig can be exercised without a real namespace switch.
+type fakeNetNS struct{}
+
+func (f *fakeNetNS) Do(toRun func(ns.NetNS) error) error { return toRun(f) }
+func (f *fakeNetNS) Set() error                          { return nil }
+func (f *fakeNetNS) Path() string                        { return "" }
+func (f *fakeNetNS) Fd() uintptr                         { return 0 }
+func (f *fakeNetNS) Close() error                        { return nil }
+
+func pfLinkWithVF(vf netlink.VfInfo) *netlink.Dummy {
+	return &netlink.Dummy{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: "pf0",
+			Vfs:  []netlink.VfInfo{vf},
+		},
+	}
+}
+
+func TestCheckPFVfStateMatches(t *testing.T) {
+	vlan := 100
+	nl := mocks.NewNetlinkManager(t)
+	nl.On("LinkByName", "pf0").Return(pfLinkWithVF(netlink.VfInfo{Vlan: vlan, Trust: 1, Spoofchk: true}), nil)
+
+	conf := &config.NetConf{Master: "pf0", VFID: 0, Vlan: &vlan, Trust: "on", SpoofChk: "on"}
+	sm := &sriovManager{nLink: nl}
+
+	assert.NoError(t, sm.checkPFVfState(conf))
+}
+
+func TestCheckPFVfStateVlanMismatch(t *testing.T) {
+	wantVlan := 100
+	nl := mocks.NewNetlinkManager(t)
+	nl.On("LinkByName", "pf0").Return(pfLinkWithVF(netlink.VfInfo{Vlan: 200}), nil)
+
+	conf := &config.NetConf{Master: "pf0", VFID: 0, Vlan: &wantVlan}
+	sm := &sriovManager{nLink: nl}
+
+	err := sm.checkPFVfState(conf)
+	assert.Error(t, err)
+}
+
+func TestCheckPFVfStateTrustMismatch(t *testing.T) {
+	nl := mocks.NewNetlinkManager(t)
+	nl.On("LinkByName", "pf0").Return(pfLinkWithVF(netlink.VfInfo{Trust: 0}), nil)
+
+	conf := &config.NetConf{Master: "pf0", VFID: 0, Trust: "on"}
+	sm := &sriovManager{nLink: nl}
+
+	err := sm.checkPFVfState(conf)
+	assert.Error(t, err)
+}
+
+func TestCheckPFVfStateMissingVF(t *testing.T) {
+	nl := mocks.NewNetlinkManager(t)
+	nl.On("LinkByName", "pf0").Return(pfLinkWithVF(netlink.VfInfo{}), nil)
+
+	conf := &config.NetConf{Master: "pf0", VFID: 5}
+	sm := &sriovManager{nLink: nl}
+
+	err := sm.checkPFVfState(conf)
+	assert.Error(t, err)
+}
+
+func TestCheckVFConfigSkipsPFStateWhenExternallyManaged(t *testing.T) {
+	nl := mocks.NewNetlinkManager(t)
+	nl.On("LinkByName", "net1").Return(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "net1"}}, nil)
+
+	vlan := 100
+	conf := &config.NetConf{Master: "pf0", VFID: 0, ExternallyManaged: true, Vlan: &vlan}
+	sm := &sriovManager{nLink: nl}
+
+	// No "LinkByName" expectation is registered for "pf0": if
+	// ExternallyManaged didn't skip checkPFVfState, the mock call below
+	// would fail the test for an unexpected call.
+	assert.NoError(t, sm.CheckVFConfig(conf, "net1", &fakeNetNS{}))
+}
+
+func TestMoveRdmaDeviceToNetnsRequiresExclusiveMode(t *testing.T) {
+	nl := mocks.NewNetlinkManager(t)
+	conf := &config.NetConf{DeviceID: "0000:00:00.0", RdmaIsolation: true}
+	sm := &sriovManager{nLink: nl}
+
+	// In this test environment the RDMA subsystem is either absent or in
+	// "shared" mode, so moveRdmaDeviceToNetns must fail before ever
+	// touching the netlink manager or the netns (no mock expectations are
+	// registered for RdmaLinkByName/RdmaLinkSetNsFd).
+	err := sm.moveRdmaDeviceToNetns(conf, &fakeNetNS{})
+	assert.Error(t, err)
+}
+
+func TestGetMacAddressForResultFallsBackToOriginal(t *testing.T) {
+	conf := &config.NetConf{}
+	conf.OrigVfState.EffectiveMAC = "aa:bb:cc:dd:ee:ff"
+
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", config.GetMacAddressForResult(conf))
+
+	conf.MAC = "11:22:33:44:55:66"
+	assert.Equal(t, "11:22:33:44:55:66", config.GetMacAddressForResult(conf))
+}
@@ -206,6 +206,43 @@ func (_m *NetlinkManager) LinkSetVfVlanQos(_a0 netlink.Link, _a1 int, _a2 int, _
 	return r0
 }
 
+// RdmaLinkByName provides a mock function with given fields: _a0
+func (_m *NetlinkManager) RdmaLinkByName(_a0 string) (*netlink.RdmaLink, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *netlink.RdmaLink
+	if rf, ok := ret.Get(0).(func(string) *netlink.RdmaLink); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*netlink.RdmaLink)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RdmaLinkSetNsFd provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) RdmaLinkSetNsFd(_a0 *netlink.RdmaLink, _a1 uint32) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*netlink.RdmaLink, uint32) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewNetlinkManager interface {
 	mock.TestingT
 	Cleanup(func())
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// sysctlNetDir is the only subtree under /proc/sys that sriov-cni's
+// NetConf.sysctl/pfSysctl blocks are allowed to write to.
+const sysctlNetDir = "/proc/sys/net/"
+
+// SysctlPathForKey translates a sysctl key such as
+// "net.ipv4.conf.IFNAME.rp_filter" into the corresponding path under
+// /proc/sys, substituting ifName for the literal "IFNAME" placeholder.
+// It returns an error if the resulting path does not resolve under
+// /proc/sys/net, so a config block can never be used to write arbitrary
+// sysctls.
+func SysctlPathForKey(key, ifName string) (string, error) {
+	parts := strings.Split(key, ".")
+	for i, p := range parts {
+		if p == "IFNAME" {
+			parts[i] = ifName
+		}
+	}
+
+	path := filepath.Clean(filepath.Join("/proc/sys", filepath.Join(parts...)))
+	if !strings.HasPrefix(path, sysctlNetDir) {
+		return "", fmt.Errorf("sysctl key %q is not allowed: must resolve under %s", key, sysctlNetDir)
+	}
+
+	return path, nil
+}
+
+// ReadSysctl returns the current value of the sysctl identified by key,
+// for interface ifName.
+func ReadSysctl(key, ifName string) (string, error) {
+	path, err := SysctlPathForKey(key, ifName)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read sysctl %q: %v", key, err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteSysctl sets the sysctl identified by key, for interface ifName, to
+// value.
+func WriteSysctl(key, ifName, value string) error {
+	path, err := SysctlPathForKey(key, ifName)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write sysctl %q=%q: %v", key, value, err)
+	}
+
+	return nil
+}
+
+// ApplySysctls writes each key/value in sysctls for ifName, returning the
+// previous values so the caller can revert them later. On error, any
+// sysctls already applied during this call are left in place; the caller
+// is expected to revert the whole set (including partial application)
+// from the returned map.
+func ApplySysctls(sysctls map[string]string, ifName string) (map[string]string, error) {
+	orig := make(map[string]string, len(sysctls))
+
+	for key, value := range sysctls {
+		prev, err := ReadSysctl(key, ifName)
+		if err != nil {
+			return orig, err
+		}
+		orig[key] = prev
+
+		if err := WriteSysctl(key, ifName, value); err != nil {
+			return orig, err
+		}
+	}
+
+	return orig, nil
+}
+
+// RevertSysctls restores each key in orig for ifName to its cached value.
+// It keeps going on error so a single stale/renamed key does not prevent
+// the rest of the sysctls from being reverted, returning the last error
+// seen.
+func RevertSysctls(orig map[string]string, ifName string) error {
+	var lastErr error
+
+	for key, value := range orig {
+		if err := WriteSysctl(key, ifName, value); err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSysctlPathForKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		ifName  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "valid key substitutes IFNAME",
+			key:    "net.ipv4.conf.IFNAME.rp_filter",
+			ifName: "net0",
+			want:   "/proc/sys/net/ipv4/conf/net0/rp_filter",
+		},
+		{
+			name:   "valid key with no IFNAME placeholder",
+			key:    "net.core.somaxconn",
+			ifName: "net0",
+			want:   "/proc/sys/net/core/somaxconn",
+		},
+		{
+			name:    "key outside net/ subtree is rejected",
+			key:     "kernel.panic",
+			ifName:  "net0",
+			wantErr: true,
+		},
+		{
+			name:    "traversal via the key is rejected",
+			key:     "net.ipv4.conf.IFNAME.../../../../etc/passwd",
+			ifName:  "net0",
+			wantErr: true,
+		},
+		{
+			name:    "traversal via ifName is rejected",
+			key:     "net.ipv4.conf.IFNAME.rp_filter",
+			ifName:  "../../../../etc",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SysctlPathForKey(tt.key, tt.ifName)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestApplySysctlsRejectsDisallowedKeyWithoutPartialIO(t *testing.T) {
+	orig, err := ApplySysctls(map[string]string{
+		"kernel.panic": "1",
+	}, "net0")
+
+	assert.Error(t, err)
+	assert.Empty(t, orig)
+}
+
+func TestRevertSysctlsKeepsGoingOnError(t *testing.T) {
+	err := RevertSysctls(map[string]string{
+		"kernel.panic":                   "0",
+		"net.ipv4.conf.IFNAME.rp_filter": "1",
+	}, "net0")
+
+	// Both keys fail in this sandbox (kernel.panic is disallowed, and
+	// /proc/sys/net is not present), but RevertSysctls must still
+	// process every key rather than bailing out on the first error.
+	assert.Error(t, err)
+}
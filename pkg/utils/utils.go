@@ -0,0 +1,400 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/j-keck/arping"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// allocatedPCIFileName is the name of the file, stored under the CNI
+	// data dir, that tracks which PCI addresses are currently in use.
+	allocatedPCIFileName = "allocated_pci.json"
+)
+
+// VfState represents the administrative state of a VF before sriov-cni
+// touched it, so it can be restored on cmdDel.
+type VfState struct {
+	HostIFName   string
+	OrigLinkName string
+	AdminMAC     string
+	EffectiveMAC string
+	Vlan         int
+	VlanQoS      int
+	MinTxRate    int
+	MaxTxRate    int
+	SpoofChk     bool
+	Trust        bool
+	LinkState    uint32
+	RdmaDevName  string
+}
+
+// NetlinkManager is an interface to mock netlink.
+type NetlinkManager interface {
+	LinkByName(string) (netlink.Link, error)
+	LinkSetVfVlan(netlink.Link, int, int) error
+	LinkSetVfVlanQos(netlink.Link, int, int, int) error
+	LinkSetVfHardwareAddr(netlink.Link, int, net.HardwareAddr) error
+	LinkSetHardwareAddr(netlink.Link, net.HardwareAddr) error
+	LinkSetUp(netlink.Link) error
+	LinkSetDown(netlink.Link) error
+	LinkSetNsFd(netlink.Link, int) error
+	LinkSetName(netlink.Link, string) error
+	LinkSetVfRate(netlink.Link, int, int, int) error
+	LinkSetVfSpoofchk(netlink.Link, int, bool) error
+	LinkSetVfTrust(netlink.Link, int, bool) error
+	LinkSetVfState(netlink.Link, int, uint32) error
+	RdmaLinkByName(string) (*netlink.RdmaLink, error)
+	RdmaLinkSetNsFd(*netlink.RdmaLink, uint32) error
+}
+
+// MyNetlink is a wrapper of netlink that implements NetlinkManager.
+type MyNetlink struct {
+}
+
+func (n *MyNetlink) LinkByName(name string) (netlink.Link, error) {
+	return netlink.LinkByName(name)
+}
+
+func (n *MyNetlink) LinkSetVfVlan(link netlink.Link, vfIndex int, vlan int) error {
+	return netlink.LinkSetVfVlan(link, vfIndex, vlan)
+}
+
+func (n *MyNetlink) LinkSetVfVlanQos(link netlink.Link, vfIndex int, vlan int, qos int) error {
+	return netlink.LinkSetVfVlanQos(link, vfIndex, vlan, qos)
+}
+
+func (n *MyNetlink) LinkSetVfHardwareAddr(link netlink.Link, vfIndex int, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetVfHardwareAddr(link, vfIndex, hwaddr)
+}
+
+func (n *MyNetlink) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetHardwareAddr(link, hwaddr)
+}
+
+func (n *MyNetlink) LinkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+
+func (n *MyNetlink) LinkSetDown(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+
+func (n *MyNetlink) LinkSetNsFd(link netlink.Link, fd int) error {
+	return netlink.LinkSetNsFd(link, fd)
+}
+
+func (n *MyNetlink) LinkSetName(link netlink.Link, name string) error {
+	return netlink.LinkSetName(link, name)
+}
+
+func (n *MyNetlink) LinkSetVfRate(link netlink.Link, vfIndex int, minRate, maxRate int) error {
+	return netlink.LinkSetVfRate(link, vfIndex, minRate, maxRate)
+}
+
+func (n *MyNetlink) LinkSetVfSpoofchk(link netlink.Link, vfIndex int, check bool) error {
+	return netlink.LinkSetVfSpoofchk(link, vfIndex, check)
+}
+
+func (n *MyNetlink) LinkSetVfTrust(link netlink.Link, vfIndex int, trust bool) error {
+	return netlink.LinkSetVfTrust(link, vfIndex, trust)
+}
+
+func (n *MyNetlink) LinkSetVfState(link netlink.Link, vfIndex int, state uint32) error {
+	return netlink.LinkSetVfState(link, vfIndex, state)
+}
+
+func (n *MyNetlink) RdmaLinkByName(name string) (*netlink.RdmaLink, error) {
+	return netlink.RdmaLinkByName(name)
+}
+
+func (n *MyNetlink) RdmaLinkSetNsFd(link *netlink.RdmaLink, fd uint32) error {
+	return netlink.RdmaLinkSetNsFd(link, fd)
+}
+
+// RdmaNetnsMode describes the RDMA subsystem's namespace mode, as
+// reported by "rdma system" / RDMA_NLDEV_CMD_SYS_GET.
+const (
+	RdmaNetnsModeShared    = "shared"
+	RdmaNetnsModeExclusive = "exclusive"
+)
+
+// GetRdmaNamespaceMode returns the RDMA subsystem's current namespace
+// mode ("shared" or "exclusive").
+func GetRdmaNamespaceMode() (string, error) {
+	return netlink.RdmaSystemGetNetnsMode()
+}
+
+// GetRdmaDeviceName returns the RDMA device name associated with the
+// netdevice at pciAddr, or "" if the device has no RDMA child.
+func GetRdmaDeviceName(pciAddr string) (string, error) {
+	ibDir := filepath.Join("/sys/bus/pci/devices", pciAddr, "infiniband")
+	entries, err := ioutil.ReadDir(ibDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read infiniband directory of device %q: %v", pciAddr, err)
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+	return entries[0].Name(), nil
+}
+
+// GetVFLinkName returns the host netdevice name of the VF with the given
+// PCI address.
+func GetVFLinkName(pciAddr string) (string, error) {
+	vfDir := filepath.Join("/sys/bus/pci/devices", pciAddr, "net")
+	entries, err := ioutil.ReadDir(vfDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read net directory of device %q: %v", pciAddr, err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("no net device found for %q", pciAddr)
+	}
+	return entries[0].Name(), nil
+}
+
+// GetVfid returns the VF index for a given PCI address of a VF belonging to master.
+func GetVfid(addr string, master string) (int, error) {
+	vfTotal, err := GetSriovNumVfs(master)
+	if err != nil {
+		return -1, err
+	}
+	for vf := 0; vf < vfTotal; vf++ {
+		vfDir := filepath.Join(NetSysDir, master, "device", fmt.Sprintf("virtfn%d", vf))
+		_, err := os.Lstat(vfDir)
+		if err != nil {
+			continue
+		}
+		pciinfo, err := filepath.EvalSymlinks(vfDir)
+		if err != nil {
+			return -1, fmt.Errorf("failed to read symlink %q: %v", vfDir, err)
+		}
+		pciAddr := filepath.Base(pciinfo)
+		if pciAddr == addr {
+			return vf, nil
+		}
+	}
+	return -1, fmt.Errorf("unable to get VF ID with PCI address %s of PF %s", addr, master)
+}
+
+// NetSysDir is the sysfs directory holding per-net-device information.
+const NetSysDir = "/sys/class/net"
+
+// GetSriovNumVfs returns the number of VFs configured on a PF.
+func GetSriovNumVfs(ifName string) (int, error) {
+	var vfTotal int
+
+	sriovFile := filepath.Join(NetSysDir, ifName, "device/sriov_numvfs")
+	if _, err := os.Lstat(sriovFile); err != nil {
+		return vfTotal, fmt.Errorf("failed to open the sriov_numfs of device %q: %v", ifName, err)
+	}
+
+	data, err := ioutil.ReadFile(sriovFile)
+	if err != nil {
+		return vfTotal, fmt.Errorf("failed to read the sriov_numfs of device %q: %v", ifName, err)
+	}
+
+	if len(data) == 0 {
+		return vfTotal, fmt.Errorf("no data in the file %q", sriovFile)
+	}
+
+	sriovNumfs := strings.TrimSpace(string(data))
+	vfTotal, err = strconv.Atoi(sriovNumfs)
+	if err != nil {
+		return vfTotal, fmt.Errorf("failed to convert sriov_numfs(byte value) to int of device %q: %v", ifName, err)
+	}
+
+	return vfTotal, nil
+}
+
+// AnnounceIPs sends a gratuitous ARP over ifName for each IPv4 address in
+// the given IP configs, to update neighbors' caches after an IP has moved
+// to a different link-layer address. IPv6 addresses are skipped: no
+// unsolicited neighbor advertisements are sent.
+func AnnounceIPs(ifName string, ips []*current.IPConfig) error {
+	for _, ipc := range ips {
+		if ipc.Address.IP.To4() != nil {
+			if err := arping.GratuitousArpOverIfaceByName(ipc.Address.IP, ifName); err != nil {
+				return fmt.Errorf("failed to send gratuitous ARP over %q: %v", ifName, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SaveNetConf stores the given NetConf in the cni data dir, keyed on
+// ContainerID and ifName, so cmdDel and cmdCheck can retrieve it later.
+func SaveNetConf(cid, dataDir, ifName string, conf interface{}) error {
+	netConfBytes, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("error serializing delegate netconf: %v", err)
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the sriov data directory(%q): %v", dataDir, err)
+	}
+
+	path := filepath.Join(dataDir, cid+"-"+ifName)
+
+	err = ioutil.WriteFile(path, netConfBytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
+	}
+
+	return nil
+}
+
+// ReadNetConf reads and returns the cached NetConf bytes for cid/ifName.
+func ReadNetConf(dataDir, cid, ifName string) ([]byte, string, error) {
+	path := filepath.Join(dataDir, cid+"-"+ifName)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("error reading cached NetConf in %q: %v", path, err)
+	}
+	return data, path, nil
+}
+
+// CachedNetConfEntry is one cached NetConf file found under a CNI data
+// directory.
+type CachedNetConfEntry struct {
+	Path  string
+	Bytes []byte
+}
+
+// ListCachedNetConfs returns every cached NetConf file under dataDir, for
+// cmdGC to scan for stale "persistent" attachments.
+func ListCachedNetConfs(dataDir string) ([]CachedNetConfEntry, error) {
+	dirEntries, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error listing cached NetConf in %q: %v", dataDir, err)
+	}
+
+	var entries []CachedNetConfEntry
+	for _, e := range dirEntries {
+		if e.IsDir() || e.Name() == allocatedPCIFileName {
+			continue
+		}
+
+		path := filepath.Join(dataDir, e.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, CachedNetConfEntry{Path: path, Bytes: data})
+	}
+
+	return entries, nil
+}
+
+// CleanCachedNetConf removes the cached NetConf file at the given path.
+func CleanCachedNetConf(path string) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing NetConf file %q: %v", path, err)
+	}
+	return nil
+}
+
+// PCIAllocator tracks which VF PCI addresses are currently allocated to a
+// netns, persisting the mapping under dataDir.
+type PCIAllocator struct {
+	dataDir string
+}
+
+// NewPCIAllocator returns a PCIAllocator rooted at dataDir.
+func NewPCIAllocator(dataDir string) *PCIAllocator {
+	return &PCIAllocator{dataDir: dataDir}
+}
+
+func (p *PCIAllocator) getAllocatedPCIFilePath() string {
+	return filepath.Join(p.dataDir, allocatedPCIFileName)
+}
+
+func (p *PCIAllocator) readAllocatedPCI() (map[string]string, error) {
+	allocs := map[string]string{}
+
+	data, err := ioutil.ReadFile(p.getAllocatedPCIFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return allocs, nil
+		}
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return allocs, nil
+	}
+
+	if err := json.Unmarshal(data, &allocs); err != nil {
+		return nil, err
+	}
+
+	return allocs, nil
+}
+
+func (p *PCIAllocator) writeAllocatedPCI(allocs map[string]string) error {
+	if err := os.MkdirAll(p.dataDir, 0700); err != nil {
+		return fmt.Errorf("failed to create the sriov data directory(%q): %v", p.dataDir, err)
+	}
+
+	data, err := json.Marshal(allocs)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p.getAllocatedPCIFilePath(), data, 0600)
+}
+
+// SaveAllocatedPCI marks pciAddr as allocated to the given netns path.
+func (p *PCIAllocator) SaveAllocatedPCI(pciAddr, netns string) error {
+	allocs, err := p.readAllocatedPCI()
+	if err != nil {
+		return err
+	}
+
+	allocs[pciAddr] = netns
+
+	return p.writeAllocatedPCI(allocs)
+}
+
+// DeleteAllocatedPCI releases a previously allocated pciAddr.
+func (p *PCIAllocator) DeleteAllocatedPCI(pciAddr string) error {
+	allocs, err := p.readAllocatedPCI()
+	if err != nil {
+		return err
+	}
+
+	delete(allocs, pciAddr)
+
+	return p.writeAllocatedPCI(allocs)
+}
+
+// GetAllocatedNetns returns the netns path that pciAddr is currently
+// allocated to, or "" if it isn't allocated.
+func (p *PCIAllocator) GetAllocatedNetns(pciAddr string) (string, error) {
+	allocs, err := p.readAllocatedPCI()
+	if err != nil {
+		return "", err
+	}
+
+	return allocs[pciAddr], nil
+}